@@ -2,6 +2,9 @@ package user
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
@@ -14,10 +17,12 @@ import (
 	emailUtil "github.com/ente-io/museum/pkg/utils/email"
 	"github.com/ente-io/museum/pkg/utils/time"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 const (
 	InactiveUserDeletionJobLock = "inactive_user_deletion_mail_lock"
+	InactiveUserPurgeJobLock    = "inactive_user_scheduled_purge_lock"
 
 	inactiveUserDeletionBatchSize    = 500
 	inactiveUserDeletionFromName     = "Ente"
@@ -27,19 +32,36 @@ const (
 	InactiveUserDeletionWarn11mTemplateID   = "inactive_user_deletion_warn_11m"
 	InactiveUserDeletionWarn12m7dTemplateID = "inactive_user_deletion_warn_12m_7d"
 	InactiveUserDeletionWarn12m1dTemplateID = "inactive_user_deletion_warn_12m_1d"
+	InactiveUserDeletionScheduledTemplateID = "inactive_user_deletion_scheduled_12m"
 	InactiveUserDeletionFinalTemplateID     = "inactive_user_deletion_confirm_12m"
 
 	inactiveUserDeletionWarn11mTemplate   = "inactive-user-deletion/warn_11m.html"
 	inactiveUserDeletionWarn12m7dTemplate = "inactive-user-deletion/warn_12m_7d.html"
 	inactiveUserDeletionWarn12m1dTemplate = "inactive-user-deletion/warn_12m_1d.html"
+	inactiveUserDeletionScheduledTemplate = "inactive-user-deletion/scheduled_12m.html"
 	inactiveUserDeletionFinalTemplate     = "inactive-user-deletion/confirm_12m.html"
 
 	inactiveUserDeletionWarn11mSubject   = "Your Ente account is scheduled for deletion due to inactivity"
 	inactiveUserDeletionWarn12m7dSubject = "Reminder: Your Ente account will be deleted in 7 days due to inactivity"
 	inactiveUserDeletionWarn12m1dSubject = "REMINDER: Your Ente account will be deleted tomorrow due to inactivity"
+	inactiveUserDeletionScheduledSubject = "Your Ente account has been scheduled for deletion"
 	inactiveUserDeletionFinalSubject     = "Your Ente account has been deleted"
 )
 
+// inactiveUserDeletionGraceDefault is how long a user has, after the 12
+// month stage fires, to cancel the scheduled purge by logging in or by
+// visiting the cancellation link before ProcessPendingAccountPurges
+// actually deletes them. Overridable via `inactive-user.grace-period-days`.
+const inactiveUserDeletionGraceDefault = 7
+
+func inactiveUserDeletionGraceInMicroSeconds() int64 {
+	days := viper.GetInt64("inactive-user.grace-period-days")
+	if days <= 0 {
+		days = inactiveUserDeletionGraceDefault
+	}
+	return days * inactiveUserOneDayInMicroSeconds
+}
+
 const (
 	inactiveUserOneDayInMicroSeconds = 24 * time.MicroSecondsInOneHour
 
@@ -53,11 +75,16 @@ const (
 	inactiveUserGap12mMinus1dTo12m = inactiveUserOneDayInMicroSeconds
 )
 
+// inactiveUserDeletionTemplateIDs gates the warning chain: the scheduled-
+// deletion email (sent once the grace period begins) is the last rung of
+// the chain, not the confirm-deletion email, since the actual purge now
+// happens on a separate pass driven by scheduled_purge_at rather than by
+// replaying lastActivity.
 var inactiveUserDeletionTemplateIDs = []string{
 	InactiveUserDeletionWarn11mTemplateID,
 	InactiveUserDeletionWarn12m7dTemplateID,
 	InactiveUserDeletionWarn12m1dTemplateID,
-	InactiveUserDeletionFinalTemplateID,
+	InactiveUserDeletionScheduledTemplateID,
 }
 
 type inactivityEmailStage string
@@ -67,14 +94,16 @@ const (
 	inactivityEmailStageWarn11m   inactivityEmailStage = "warn_11m"
 	inactivityEmailStageWarn12m7d inactivityEmailStage = "warn_12m_7d"
 	inactivityEmailStageWarn12m1d inactivityEmailStage = "warn_12m_1d"
-	inactivityEmailStageFinal     inactivityEmailStage = "confirm_12m"
+	inactivityEmailStageScheduled inactivityEmailStage = "scheduled_12m"
 )
 
 type inactivityEmailStageConfig struct {
 	TemplateID   string
 	TemplateName string
 	Subject      string
-	IsFinal      bool
+	// SchedulesPurge marks the stage that starts the grace period instead
+	// of deleting the account outright.
+	SchedulesPurge bool
 }
 
 // InactiveUserOrchestrator sends inactivity warning emails and final manual
@@ -147,6 +176,140 @@ func (c *InactiveUserOrchestrator) ProcessInactiveUsers() {
 	}).Info("Completed inactive user processing")
 }
 
+// ProcessPendingAccountPurges runs on its own scheduler pass, separate from
+// ProcessInactiveUsers, and actually deletes users whose grace period
+// (started when they entered inactivityEmailStageScheduled) has elapsed.
+// A user is only purged if there's been no login/token activity since
+// deletion_requested_at was set; otherwise the pending deletion is
+// cleared, mirroring what POST /users/cancel-scheduled-deletion does.
+func (c *InactiveUserOrchestrator) ProcessPendingAccountPurges() {
+	if c.UserController == nil {
+		log.Error("Skipping pending account purges because user controller is not wired up")
+		return
+	}
+
+	lockUntil := time.MicrosecondsAfterHours(24)
+	if !c.LockController.TryLock(InactiveUserPurgeJobLock, lockUntil) {
+		log.Info("Skipping pending account purge processing because another instance is running")
+		return
+	}
+	defer c.LockController.ReleaseLock(InactiveUserPurgeJobLock)
+
+	now := time.Microseconds()
+	var afterUserID int64
+	processedUsers := 0
+	purgedUsers := 0
+
+	for {
+		candidates, err := c.UserRepo.GetUsersPendingPurgeBefore(now, afterUserID, inactiveUserDeletionBatchSize)
+		if err != nil {
+			log.WithError(err).Error("Failed to fetch users pending purge")
+			return
+		}
+		if len(candidates) == 0 {
+			break
+		}
+
+		for _, candidate := range candidates {
+			afterUserID = candidate.UserID
+			processedUsers++
+			purged, err := c.processPendingPurgeCandidate(candidate, now)
+			if err != nil {
+				log.WithError(err).WithField("user_id", candidate.UserID).Error("Failed to process pending account purge candidate")
+				continue
+			}
+			if purged {
+				purgedUsers++
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"processed_users": processedUsers,
+		"purged_users":    purgedUsers,
+	}).Info("Completed pending account purge processing")
+}
+
+func (c *InactiveUserOrchestrator) processPendingPurgeCandidate(candidate repo.UserPendingDeletionCandidate, now int64) (bool, error) {
+	if now < candidate.ScheduledPurgeAt {
+		return false, nil
+	}
+
+	latestActivity, found, err := c.latestTokenActivity(candidate.UserID)
+	if err != nil {
+		return false, err
+	}
+	// Any login/token activity since the purge was scheduled cancels it —
+	// HandleAutomatedAccountDeletion enforces the same check, this just
+	// avoids the extra work of calling into it.
+	if found && latestActivity >= candidate.DeletionRequestedAt {
+		if err := c.UserRepo.ClearPendingDeletion(candidate.UserID); err != nil {
+			return false, err
+		}
+		log.WithField("user_id", candidate.UserID).Info("Cancelling scheduled deletion because the user has been active since")
+		return false, nil
+	}
+
+	// Fetch the user before deleting, the same way processCandidate does —
+	// once HandleAutomatedAccountDeletion succeeds, Get will start
+	// returning ente.ErrUserDeleted for this user like it does everywhere
+	// else in this file, so the confirmation email would never go out if
+	// we looked the user up afterwards instead.
+	user, err := c.UserRepo.Get(candidate.UserID)
+	if err != nil {
+		if errors.Is(err, ente.ErrUserDeleted) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	deleteLogger := log.WithFields(log.Fields{
+		"user_id": candidate.UserID,
+		"req_ctx": "inactive_account_deletion",
+	})
+	if _, err := c.UserController.HandleAutomatedAccountDeletion(context.Background(), candidate.UserID, deleteLogger); err != nil {
+		return false, err
+	}
+
+	templateData := map[string]interface{}{"Email": user.Email}
+	if emailErr := emailUtil.SendTemplatedEmailV2(
+		[]string{user.Email},
+		inactiveUserDeletionFromName,
+		inactiveUserDeletionFromEmail,
+		inactiveUserDeletionFinalSubject,
+		inactiveUserDeletionBaseTemplate,
+		inactiveUserDeletionFinalTemplate,
+		templateData,
+		nil,
+	); emailErr != nil {
+		log.WithError(emailErr).WithField("user_id", candidate.UserID).Error("Failed to send deletion confirmation email")
+	}
+
+	c.DiscordController.NotifyAdminAction(
+		fmt.Sprintf("Inactive user %d was purged after a %d day grace period with no activity", candidate.UserID,
+			inactiveUserDeletionGraceInMicroSeconds()/inactiveUserOneDayInMicroSeconds))
+
+	return true, nil
+}
+
+// CancelScheduledDeletion clears a pending deletion if token matches the
+// signed cancellation token issued when the grace period began. It's a
+// no-op (not an error) if the user has no pending deletion, so retries and
+// stale links don't surface confusing errors to the user.
+func (c *InactiveUserOrchestrator) CancelScheduledDeletion(userID int64, token string) error {
+	pending, found, err := c.UserRepo.GetPendingDeletion(userID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	if !verifyCancellationToken(userID, pending.ScheduledPurgeAt, token) {
+		return ente.ErrPermissionDenied
+	}
+	return c.UserRepo.ClearPendingDeletion(userID)
+}
+
 func (c *InactiveUserOrchestrator) processCandidate(candidate repo.UserInactivityCandidate, now int64) (bool, error) {
 	user, err := c.UserRepo.Get(candidate.UserID)
 	if err != nil {
@@ -160,7 +323,7 @@ func (c *InactiveUserOrchestrator) processCandidate(candidate repo.UserInactivit
 		return false, nil
 	}
 
-	lastActivity, found, err := c.UserRepo.GetLatestTokenActivity(user.ID)
+	lastActivity, found, err := c.latestTokenActivity(user.ID)
 	if err != nil {
 		return false, err
 	}
@@ -182,14 +345,14 @@ func (c *InactiveUserOrchestrator) processCandidate(candidate repo.UserInactivit
 		return false, nil
 	}
 
-	if config.IsFinal {
-		if c.UserController == nil {
-			return false, fmt.Errorf("inactive user deletion requires user controller")
-		}
-
-		// Re-check right before deletion to avoid deleting users who became active
-		// after earlier reads in long processing runs.
-		latestActivity, latestFound, err := c.UserRepo.GetLatestTokenActivity(user.ID)
+	var cancellationToken string
+	if config.SchedulesPurge {
+		// Re-check right before scheduling to avoid flagging users who became
+		// active after earlier reads in long processing runs. Uses the same
+		// per-token wrapper as the initial stage resolution above, not the
+		// legacy aggregate directly, so a user with one stale token and one
+		// fresh token can't slip through here.
+		latestActivity, latestFound, err := c.latestTokenActivity(user.ID)
 		if err != nil {
 			return false, err
 		}
@@ -200,16 +363,14 @@ func (c *InactiveUserOrchestrator) processCandidate(candidate repo.UserInactivit
 		if err != nil {
 			return false, err
 		}
-		if latestStage != inactivityEmailStageFinal {
-			log.WithField("user_id", user.ID).Info("Skipping inactive user deletion because user is no longer in final stage")
+		if latestStage != inactivityEmailStageScheduled {
+			log.WithField("user_id", user.ID).Info("Skipping scheduled deletion because user is no longer in the scheduling stage")
 			return false, nil
 		}
 
-		deleteLogger := log.WithFields(log.Fields{
-			"user_id": user.ID,
-			"req_ctx": "inactive_account_deletion",
-		})
-		if _, err := c.UserController.HandleAutomatedAccountDeletion(context.Background(), user.ID, deleteLogger); err != nil {
+		scheduledPurgeAt := now + inactiveUserDeletionGraceInMicroSeconds()
+		cancellationToken = generateCancellationToken(user.ID, scheduledPurgeAt)
+		if err := c.UserRepo.SetPendingDeletion(user.ID, now, scheduledPurgeAt); err != nil {
 			return false, err
 		}
 	}
@@ -219,6 +380,9 @@ func (c *InactiveUserOrchestrator) processCandidate(candidate repo.UserInactivit
 		"Email":        user.Email,
 		"DeletionDate": deletionDate,
 	}
+	if cancellationToken != "" {
+		templateData["CancellationToken"] = cancellationToken
+	}
 	if err := emailUtil.SendTemplatedEmailV2(
 		[]string{user.Email},
 		inactiveUserDeletionFromName,
@@ -243,15 +407,41 @@ func (c *InactiveUserOrchestrator) processCandidate(candidate repo.UserInactivit
 		"deletion_date": deletionDate,
 	}).Info("Sent inactive user email")
 
-	if config.IsFinal {
+	if config.SchedulesPurge {
 		c.DiscordController.NotifyAdminAction(
-			fmt.Sprintf("Inactive user %d (%s) reached 12 months inactivity and account deletion was initiated",
+			fmt.Sprintf("Inactive user %d (%s) reached 12 months inactivity and was scheduled for deletion",
 				user.ID, user.Email))
 	}
 
 	return true, nil
 }
 
+// latestTokenActivity computes the most recent activity across a user's
+// active tokens from per-token last_seen_at, instead of trusting a single
+// aggregate column that can go stale. A user whose oldest token has been
+// idle for 12 months but whose newest token was used yesterday is
+// correctly treated as active. Falls back to the legacy aggregate if a
+// user has no per-token activity recorded yet (e.g. no request has landed
+// since TokenActivityMiddleware was deployed).
+func (c *InactiveUserOrchestrator) latestTokenActivity(userID int64) (int64, bool, error) {
+	tokens, err := c.UserRepo.GetActiveTokenActivity(userID)
+	if err != nil {
+		return 0, false, err
+	}
+	var latest int64
+	found := false
+	for _, token := range tokens {
+		if token.LastSeenAt > latest {
+			latest = token.LastSeenAt
+			found = true
+		}
+	}
+	if found {
+		return latest, true, nil
+	}
+	return c.UserRepo.GetLatestTokenActivity(userID)
+}
+
 func (c *InactiveUserOrchestrator) resolveNextStage(userID int64, lastActivity int64, now int64) (inactivityEmailStage, error) {
 	history, err := c.NotificationHistoryRepo.GetLastNotificationTimes(userID, inactiveUserDeletionTemplateIDs)
 	if err != nil {
@@ -270,8 +460,8 @@ func nextInactivityEmailStage(lastActivity int64, now int64, history map[string]
 		return inactivityEmailStageWarn11m
 	}
 
-	sentFinal := history[InactiveUserDeletionFinalTemplateID]
-	if sentFinal > lastActivity {
+	sentScheduled := history[InactiveUserDeletionScheduledTemplateID]
+	if sentScheduled > lastActivity {
 		return inactivityEmailStageNone
 	}
 
@@ -292,7 +482,7 @@ func nextInactivityEmailStage(lastActivity int64, now int64, history map[string]
 	}
 
 	if now >= sent12mMinus1d+inactiveUserGap12mMinus1dTo12m {
-		return inactivityEmailStageFinal
+		return inactivityEmailStageScheduled
 	}
 	return inactivityEmailStageNone
 }
@@ -317,12 +507,12 @@ func inactivityStageConfig(stage inactivityEmailStage) inactivityEmailStageConfi
 			TemplateName: inactiveUserDeletionWarn12m1dTemplate,
 			Subject:      inactiveUserDeletionWarn12m1dSubject,
 		}
-	case inactivityEmailStageFinal:
+	case inactivityEmailStageScheduled:
 		return inactivityEmailStageConfig{
-			TemplateID:   InactiveUserDeletionFinalTemplateID,
-			TemplateName: inactiveUserDeletionFinalTemplate,
-			Subject:      inactiveUserDeletionFinalSubject,
-			IsFinal:      true,
+			TemplateID:     InactiveUserDeletionScheduledTemplateID,
+			TemplateName:   inactiveUserDeletionScheduledTemplate,
+			Subject:        inactiveUserDeletionScheduledSubject,
+			SchedulesPurge: true,
 		}
 	default:
 		return inactivityEmailStageConfig{}
@@ -342,9 +532,26 @@ func formatDeletionDateForStage(stage inactivityEmailStage, now int64) string {
 		daysUntilDeletion = 7
 	case inactivityEmailStageWarn12m1d:
 		daysUntilDeletion = 1
-	case inactivityEmailStageFinal:
-		daysUntilDeletion = 0
+	case inactivityEmailStageScheduled:
+		deletionTime := stdtime.UnixMicro(now + inactiveUserDeletionGraceInMicroSeconds()).UTC()
+		return deletionTime.Format("02 Jan 2006")
 	}
 	deletionTime := stdtime.UnixMicro(now + daysUntilDeletion*inactiveUserOneDayInMicroSeconds).UTC()
 	return deletionTime.Format("02 Jan 2006")
 }
+
+// generateCancellationToken signs (userID, scheduledPurgeAt) with an HMAC
+// so `POST /users/cancel-scheduled-deletion` can verify the token against
+// the stored scheduled_purge_at without the token itself being stored, and
+// so the token can't be forged or replayed against a different purge
+// schedule. Keyed by `inactive-user.cancellation-secret`.
+func generateCancellationToken(userID int64, scheduledPurgeAt int64) string {
+	mac := hmac.New(sha256.New, []byte(viper.GetString("inactive-user.cancellation-secret")))
+	mac.Write([]byte(fmt.Sprintf("%d.%d", userID, scheduledPurgeAt)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyCancellationToken(userID int64, scheduledPurgeAt int64, token string) bool {
+	expected := generateCancellationToken(userID, scheduledPurgeAt)
+	return hmac.Equal([]byte(expected), []byte(token))
+}
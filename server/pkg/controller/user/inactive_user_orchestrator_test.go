@@ -0,0 +1,74 @@
+package user
+
+import "testing"
+
+func TestNextInactivityEmailStage_NotYetInactive(t *testing.T) {
+	lastActivity := int64(0)
+	now := inactiveUserWarn11MonthsInMicroSeconds - 1
+
+	if stage := nextInactivityEmailStage(lastActivity, now, nil); stage != inactivityEmailStageNone {
+		t.Fatalf("expected no stage before the 11 month mark, got %q", stage)
+	}
+}
+
+func TestNextInactivityEmailStage_FirstWarningFires(t *testing.T) {
+	lastActivity := int64(0)
+	now := inactiveUserWarn11MonthsInMicroSeconds
+
+	if stage := nextInactivityEmailStage(lastActivity, now, nil); stage != inactivityEmailStageWarn11m {
+		t.Fatalf("expected warn_11m at the 11 month mark, got %q", stage)
+	}
+}
+
+func TestNextInactivityEmailStage_WaitsOutGapBeforeNextStage(t *testing.T) {
+	lastActivity := int64(0)
+	sent11m := inactiveUserWarn11MonthsInMicroSeconds
+	history := map[string]int64{InactiveUserDeletionWarn11mTemplateID: sent11m}
+
+	beforeGap := sent11m + inactiveUserGap11mTo12mMinus7d - 1
+	if stage := nextInactivityEmailStage(lastActivity, beforeGap, history); stage != inactivityEmailStageNone {
+		t.Fatalf("expected no stage before the 11m->12m-7d gap elapses, got %q", stage)
+	}
+
+	afterGap := sent11m + inactiveUserGap11mTo12mMinus7d
+	if stage := nextInactivityEmailStage(lastActivity, afterGap, history); stage != inactivityEmailStageWarn12m7d {
+		t.Fatalf("expected warn_12m_7d once the gap elapses, got %q", stage)
+	}
+}
+
+func TestNextInactivityEmailStage_StepsThroughFullChainToScheduled(t *testing.T) {
+	lastActivity := int64(0)
+	sent11m := inactiveUserWarn11MonthsInMicroSeconds
+	sent12m7d := sent11m + inactiveUserGap11mTo12mMinus7d
+	sent12m1d := sent12m7d + inactiveUserGap12mMinus7dTo1d
+	history := map[string]int64{
+		InactiveUserDeletionWarn11mTemplateID:   sent11m,
+		InactiveUserDeletionWarn12m7dTemplateID: sent12m7d,
+		InactiveUserDeletionWarn12m1dTemplateID: sent12m1d,
+	}
+
+	now := sent12m1d + inactiveUserGap12mMinus1dTo12m
+	stage := nextInactivityEmailStage(lastActivity, now, history)
+	if stage != inactivityEmailStageScheduled {
+		t.Fatalf("expected scheduled_12m once the full chain has played out, got %q", stage)
+	}
+}
+
+func TestNextInactivityEmailStage_NewActivityResetsTheChain(t *testing.T) {
+	sent11m := inactiveUserWarn11MonthsInMicroSeconds
+	sentScheduled := sent11m + inactiveUserGap11mTo12mMinus7d + inactiveUserGap12mMinus7dTo1d + inactiveUserGap12mMinus1dTo12m
+	history := map[string]int64{
+		InactiveUserDeletionWarn11mTemplateID:   sent11m,
+		InactiveUserDeletionScheduledTemplateID: sentScheduled,
+	}
+
+	// The user was active again after the scheduled-deletion mail went out
+	// (lastActivity > 0, recorded after sentScheduled), so the stage chain
+	// should restart from scratch rather than replaying scheduled_12m.
+	newLastActivity := sentScheduled + 1
+	now := newLastActivity + inactiveUserWarn11MonthsInMicroSeconds
+	stage := nextInactivityEmailStage(newLastActivity, now, history)
+	if stage != inactivityEmailStageWarn11m {
+		t.Fatalf("expected the chain to restart at warn_11m after fresh activity, got %q", stage)
+	}
+}
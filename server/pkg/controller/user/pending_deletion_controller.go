@@ -0,0 +1,39 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ente-io/museum/ente"
+	"github.com/ente-io/museum/pkg/utils/auth"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type cancelScheduledDeletionRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// CancelScheduledDeletionHandler backs `POST /users/cancel-scheduled-deletion`,
+// letting a user who was scheduled for purge due to inactivity call off
+// the deletion by presenting the signed token from their warning email.
+func (c *InactiveUserOrchestrator) CancelScheduledDeletionHandler(ctx *gin.Context) {
+	var req cancelScheduledDeletionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	userID := auth.GetUserID(ctx.Request.Header)
+	if err := c.CancelScheduledDeletion(userID, req.Token); err != nil {
+		if errors.Is(err, ente.ErrPermissionDenied) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid or expired cancellation token"})
+			return
+		}
+		log.WithError(err).WithField("user_id", userID).Error("Failed to cancel scheduled deletion")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel scheduled deletion"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
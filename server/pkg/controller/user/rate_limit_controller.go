@@ -0,0 +1,46 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/ente-io/museum/pkg/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitController backs the `GET /users/rate-limits` endpoint so
+// clients can render accurate quota UIs instead of guessing at limits.
+type RateLimitController struct {
+	RateLimitMiddleware *middleware.RateLimitMiddleware
+}
+
+func NewRateLimitController(rateLimitMiddleware *middleware.RateLimitMiddleware) *RateLimitController {
+	return &RateLimitController{
+		RateLimitMiddleware: rateLimitMiddleware,
+	}
+}
+
+// GetRateLimits returns the caller's effective tier, the limits that come
+// with it, and the current bucket state of the global request limit and
+// the daily paste-create quota, so clients can render accurate quota UIs
+// instead of just the static config.
+func (c *RateLimitController) GetRateLimits(ctx *gin.Context) {
+	tier := c.RateLimitMiddleware.TierForContext(ctx)
+	requestLimit, requestTokensRemaining := c.RateLimitMiddleware.VisitorBucketState(ctx)
+	pasteCreateDailyLimit, pasteCreateDailyRemaining := c.RateLimitMiddleware.PasteCreateDailyQuotaState(ctx, tier)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"tier": tier.Name,
+		"limits": gin.H{
+			"requestLimitBurst":                tier.RequestLimitBurst,
+			"requestLimitReplenishSeconds":     tier.RequestLimitReplenish.Seconds(),
+			"pasteCreateDailyLimit":            tier.PasteCreateDailyLimit,
+			"publicCollectionUploadURLsPerMin": tier.PublicCollectionUploadURLsPerMin,
+		},
+		"bucketState": gin.H{
+			"requestLimit":              requestLimit,
+			"requestTokensRemaining":    requestTokensRemaining,
+			"pasteCreateDailyLimit":     pasteCreateDailyLimit,
+			"pasteCreateDailyRemaining": pasteCreateDailyRemaining,
+		},
+	})
+}
@@ -0,0 +1,59 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/ente-io/museum/pkg/repo"
+	"github.com/ente-io/museum/pkg/utils/auth"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// SessionsController backs `GET /users/sessions`, letting a user see each
+// of their active tokens along with when and where it was last used.
+type SessionsController struct {
+	UserRepo *repo.UserRepository
+}
+
+func NewSessionsController(userRepo *repo.UserRepository) *SessionsController {
+	return &SessionsController{
+		UserRepo: userRepo,
+	}
+}
+
+// sessionView is the per-token view returned by GetSessions. The token
+// itself is never returned, only its id, so a leaked response can't be
+// replayed as a session.
+type sessionView struct {
+	ID                int64  `json:"id"`
+	App               string `json:"app"`
+	CreatedAt         int64  `json:"createdAt"`
+	LastSeenAt        int64  `json:"lastSeenAt"`
+	LastSeenIP        string `json:"lastSeenIP"`
+	LastSeenUserAgent string `json:"lastSeenUserAgent"`
+}
+
+// GetSessions returns the caller's active tokens with their per-token
+// last-seen metadata.
+func (c *SessionsController) GetSessions(ctx *gin.Context) {
+	userID := auth.GetUserID(ctx.Request.Header)
+	tokens, err := c.UserRepo.GetActiveTokenActivity(userID)
+	if err != nil {
+		log.WithError(err).WithField("user_id", userID).Error("Failed to fetch sessions")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sessions"})
+		return
+	}
+
+	sessions := make([]sessionView, 0, len(tokens))
+	for _, t := range tokens {
+		sessions = append(sessions, sessionView{
+			ID:                t.ID,
+			App:               t.App,
+			CreatedAt:         t.CreatedAt,
+			LastSeenAt:        t.LastSeenAt,
+			LastSeenIP:        t.LastSeenIP,
+			LastSeenUserAgent: t.LastSeenUserAgent,
+		})
+	}
+	ctx.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
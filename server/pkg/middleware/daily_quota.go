@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	util "github.com/ente-io/museum/pkg/utils"
+	"github.com/ente-io/museum/pkg/utils/auth"
+	"github.com/ente-io/museum/pkg/utils/network"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ulule/limiter/v3"
+)
+
+// dailyQuotaClass names an endpoint class that gets a second, daily layer
+// of rate limiting on top of whatever per-minute/second limit getLimiter
+// already applies — these are the expensive or abuse-prone routes where a
+// client staying just under the per-minute limit all day can still cause
+// real damage.
+type dailyQuotaClass string
+
+const (
+	dailyQuotaPasteCreate                   dailyQuotaClass = "paste-create"
+	dailyQuotaPublicCollectionUploadURLs    dailyQuotaClass = "public-collection-upload-urls"
+	dailyQuotaPublicCollectionMultipartURLs dailyQuotaClass = "public-collection-multipart-upload-urls"
+	dailyQuotaUsersOTT                      dailyQuotaClass = "users-ott"
+	dailyQuotaUsersVerifyEmail              dailyQuotaClass = "users-verify-email"
+)
+
+// dailyQuotaTTL outlives the 24h the UTC-date bucket key implies, so a
+// slow store write right at midnight UTC can't let a visitor roll over
+// into a fresh key while the old one is still technically live.
+const dailyQuotaTTL = 26 * time.Hour
+
+// dailyQuotaPerMinDefaults mirrors the per-minute limits getLimiter
+// applies to these same paths. A daily cap derived from it (perMin * 60 *
+// 4) is used whenever `ratelimit.daily.<class>` isn't explicitly
+// configured.
+var dailyQuotaPerMinDefaults = map[dailyQuotaClass]int64{
+	dailyQuotaPasteCreate:                   10,
+	dailyQuotaPublicCollectionUploadURLs:    50,
+	dailyQuotaPublicCollectionMultipartURLs: 50,
+	dailyQuotaUsersOTT:                      10,
+	dailyQuotaUsersVerifyEmail:              10,
+}
+
+func dailyQuotaClassForPath(reqPath string) (dailyQuotaClass, bool) {
+	switch reqPath {
+	case "/paste/create":
+		return dailyQuotaPasteCreate, true
+	case "/public-collection/upload-urls":
+		return dailyQuotaPublicCollectionUploadURLs, true
+	case "/public-collection/multipart-upload-urls":
+		return dailyQuotaPublicCollectionMultipartURLs, true
+	case "/users/ott":
+		return dailyQuotaUsersOTT, true
+	case "/users/verify-email":
+		return dailyQuotaUsersVerifyEmail, true
+	}
+	return "", false
+}
+
+// dailyQuotaLimiter returns (and lazily builds) the limiter.Limiter for a
+// daily quota class, reading its cap from config the first time it's
+// needed and caching the result so config is only consulted once.
+func (r *RateLimitMiddleware) dailyQuotaLimiter(class dailyQuotaClass) (*limiter.Limiter, int64) {
+	r.dailyLimitersMu.RLock()
+	l, ok := r.dailyLimiters[class]
+	limitValue := r.dailyLimitValues[class]
+	r.dailyLimitersMu.RUnlock()
+	if ok {
+		return l, limitValue
+	}
+
+	r.dailyLimitersMu.Lock()
+	defer r.dailyLimitersMu.Unlock()
+	if l, ok := r.dailyLimiters[class]; ok {
+		return l, r.dailyLimitValues[class]
+	}
+
+	dailyLimit := viper.GetInt64(fmt.Sprintf("ratelimit.daily.%s", class))
+	if dailyLimit <= 0 {
+		dailyLimit = dailyQuotaPerMinDefaults[class] * 60 * 4
+	}
+	l = util.NewRateLimiterWithPeriod(dailyLimit, dailyQuotaTTL)
+	r.dailyLimiters[class] = l
+	r.dailyLimitValues[class] = dailyLimit
+	return l, dailyLimit
+}
+
+// tierDailyQuotaLimiter returns the limiter.Limiter enforceDailyQuota should
+// apply for (class, tier): the tier's own override when it has one for this
+// class, else the class-wide default from dailyQuotaLimiter. Only
+// dailyQuotaPasteCreate has a tier override today (PasteCreateDailyLimit);
+// every other class always falls through to the default.
+func (r *RateLimitMiddleware) tierDailyQuotaLimiter(class dailyQuotaClass, tier Tier) (*limiter.Limiter, int64) {
+	if class != dailyQuotaPasteCreate || tier.PasteCreateDailyLimit <= 0 {
+		return r.dailyQuotaLimiter(class)
+	}
+
+	cacheKey := fmt.Sprintf("%s:%s", class, tier.Name)
+	r.dailyLimitersMu.RLock()
+	l, ok := r.dailyLimiters[dailyQuotaClass(cacheKey)]
+	r.dailyLimitersMu.RUnlock()
+	if ok {
+		return l, tier.PasteCreateDailyLimit
+	}
+
+	r.dailyLimitersMu.Lock()
+	defer r.dailyLimitersMu.Unlock()
+	if l, ok := r.dailyLimiters[dailyQuotaClass(cacheKey)]; ok {
+		return l, tier.PasteCreateDailyLimit
+	}
+	l = util.NewRateLimiterWithPeriod(tier.PasteCreateDailyLimit, dailyQuotaTTL)
+	r.dailyLimiters[dailyQuotaClass(cacheKey)] = l
+	return l, tier.PasteCreateDailyLimit
+}
+
+// PasteCreateDailyQuotaState reports the caller's current daily paste-create
+// quota state (the same tier-aware limiter enforceDailyQuota checks against)
+// without consuming from it, for GET /users/rate-limits to render alongside
+// tier.PasteCreateDailyLimit.
+func (r *RateLimitMiddleware) PasteCreateDailyQuotaState(c *gin.Context, tier Tier) (limit int64, remaining int64) {
+	dailyLimiter, limitValue := r.tierDailyQuotaLimiter(dailyQuotaPasteCreate, tier)
+	limitContext, err := dailyLimiter.Peek(c, dailyQuotaKey(c, dailyQuotaPasteCreate))
+	if err != nil {
+		log.Error("Failed to peek daily rate limit", err)
+		return limitValue, limitValue
+	}
+	remaining = limitContext.Remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+	return limitValue, remaining
+}
+
+// enforceDailyQuota is the second rate-limit layer APIRateLimitMiddleware
+// applies for endpoints in dailyQuotaClassForPath, on top of the existing
+// per-minute/second limiter. It keys the bucket by (visitor-or-user,
+// endpoint-class, UTC date), so day rollovers always start a fresh quota,
+// and surfaces X-RateLimit-Daily-Remaining/-Reset so clients can back off
+// gracefully instead of hammering into 429s. Returns false (and has
+// already written the 429 response) if the daily cap was breached.
+//
+// tier.PasteCreateDailyLimit, when set, overrides the class-wide default
+// for dailyQuotaPasteCreate — every other class ignores tier entirely.
+func (r *RateLimitMiddleware) enforceDailyQuota(c *gin.Context, reqPath string, tier Tier) bool {
+	class, ok := dailyQuotaClassForPath(reqPath)
+	if !ok {
+		return true
+	}
+
+	dailyLimiter, limitValue := r.tierDailyQuotaLimiter(class, tier)
+	key := dailyQuotaKey(c, class)
+	limitContext, err := dailyLimiter.Get(c, key)
+	if err != nil {
+		log.Error("Failed to check daily rate limit", err)
+		return true // assume that limit hasn't been reached
+	}
+
+	remaining := limitContext.Remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.Header("X-RateLimit-Daily-Remaining", strconv.FormatInt(remaining, 10))
+	c.Header("X-RateLimit-Daily-Reset", strconv.FormatInt(limitContext.Reset, 10))
+
+	if limitContext.Reached {
+		go r.discordCtrl.NotifyPotentialAbuse(fmt.Sprintf("Daily rate limit (%d) breached %s", limitValue, key))
+		log.Error(fmt.Sprintf("Daily rate limit breached %s", key))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Daily rate limit breached, try again tomorrow"})
+		return false
+	}
+	return true
+}
+
+// dailyQuotaKey derives the daily bucket key: the authenticated user ID
+// when present, else the client IP, plus the endpoint class and today's
+// UTC date.
+func dailyQuotaKey(c *gin.Context, class dailyQuotaClass) string {
+	date := time.Now().UTC().Format("2006-01-02")
+	if userID := auth.GetUserID(c.Request.Header); userID != 0 {
+		return fmt.Sprintf("%d-%s-%s", userID, class, date)
+	}
+	return fmt.Sprintf("%s-%s-%s", network.GetClientIP(c), class, date)
+}
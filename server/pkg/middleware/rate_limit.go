@@ -5,7 +5,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 
 	"github.com/ente-io/museum/ente"
@@ -20,58 +20,162 @@ import (
 )
 
 type RateLimitMiddleware struct {
-	count             int64 // Use int64 for atomic operations
-	limit             int64
-	reset             time.Duration
-	ticker            *time.Ticker
 	limit10ReqPerMin  *limiter.Limiter
 	limit50ReqPerMin  *limiter.Limiter
 	limit300ReqPerMin *limiter.Limiter
 	limit200ReqPerMin *limiter.Limiter
 	limit200ReqPerSec *limiter.Limiter
 	discordCtrl       *discord.DiscordController
+
+	// visitorLimiter backs GlobalRateLimiter: a per-visitor token bucket
+	// instead of a single counter shared across every client, so one
+	// visitor tripping the limit doesn't cost every other visitor their
+	// headroom.
+	visitorLimiter *VisitorLimiter
+
+	// TierProvider resolves the caller's Tier so per-route limits can be
+	// overridden per subscription plan instead of being a single hardcoded
+	// bucket. It's optional: a nil TierProvider (or a tier with a zero
+	// value for a given field) falls back to the defaults below.
+	TierProvider   TierProvider
+	tierLimiters   map[string]*limiter.Limiter
+	tierLimitersMu sync.RWMutex
+
+	// tierVisitorLimiters backs the tier-aware path through GlobalRateLimiter:
+	// a VisitorLimiter per tier name, built lazily from that tier's
+	// RequestLimitBurst/RequestLimitReplenish. Tiers that don't override
+	// these (a zero value for either field) keep using the shared
+	// visitorLimiter above instead of getting an entry here.
+	tierVisitorLimiters   map[string]*VisitorLimiter
+	tierVisitorLimitersMu sync.RWMutex
+
+	// dailyLimiters backs enforceDailyQuota: a second, daily layer of rate
+	// limiting for the handful of expensive/abuse-prone endpoints listed in
+	// dailyQuotaClassForPath, on top of the per-minute/second limit above.
+	dailyLimiters    map[dailyQuotaClass]*limiter.Limiter
+	dailyLimitValues map[dailyQuotaClass]int64
+	dailyLimitersMu  sync.RWMutex
 }
 
-func NewRateLimitMiddleware(discordCtrl *discord.DiscordController, limit int64, reset time.Duration) *RateLimitMiddleware {
-	rl := &RateLimitMiddleware{
-		limit10ReqPerMin:  util.NewRateLimiter("10-M"),
-		limit50ReqPerMin:  util.NewRateLimiter("50-M"),
-		limit300ReqPerMin: util.NewRateLimiter("300-M"),
-		limit200ReqPerMin: util.NewRateLimiter("200-M"),
-		limit200ReqPerSec: util.NewRateLimiter("200-S"),
-		discordCtrl:       discordCtrl,
-		limit:             limit,
-		reset:             reset,
-		ticker:            time.NewTicker(reset),
-	}
-	go func() {
-		for range rl.ticker.C {
-			atomic.StoreInt64(&rl.count, 0) // Reset the count every reset interval
-		}
-	}()
-	return rl
+// NewRateLimitMiddleware builds the middleware with a global per-visitor
+// token bucket of the given burst size, refilling one token every
+// replenish interval (e.g. burst=200, replenish=300ms ~= 200 req/min per
+// visitor with bursting).
+func NewRateLimitMiddleware(discordCtrl *discord.DiscordController, burst int64, replenish time.Duration) *RateLimitMiddleware {
+	return &RateLimitMiddleware{
+		limit10ReqPerMin:    util.NewRateLimiter("10-M"),
+		limit50ReqPerMin:    util.NewRateLimiter("50-M"),
+		limit300ReqPerMin:   util.NewRateLimiter("300-M"),
+		limit200ReqPerMin:   util.NewRateLimiter("200-M"),
+		limit200ReqPerSec:   util.NewRateLimiter("200-S"),
+		discordCtrl:         discordCtrl,
+		visitorLimiter:      NewVisitorLimiter(burst, replenish),
+		tierLimiters:        make(map[string]*limiter.Limiter),
+		tierVisitorLimiters: make(map[string]*VisitorLimiter),
+		dailyLimiters:       make(map[dailyQuotaClass]*limiter.Limiter),
+		dailyLimitValues:    make(map[dailyQuotaClass]int64),
+	}
+}
+
+// SetRateLimitExemptHosts configures RateLimitExemptHosts: a comma
+// separated list of CIDRs and hostnames that bypass the global visitor
+// rate limiter entirely (trusted infra, load balancers, internal jobs).
+func (r *RateLimitMiddleware) SetRateLimitExemptHosts(raw string) {
+	r.visitorLimiter.SetExemptHosts(raw)
+}
+
+// SetTierProvider wires a TierProvider into the middleware. Called once
+// during server setup; left unset, every request resolves to the
+// hardcoded fallback tier.
+func (r *RateLimitMiddleware) SetTierProvider(provider TierProvider) {
+	r.TierProvider = provider
+}
+
+// TierForContext resolves the effective Tier for the given request,
+// falling back to today's hardcoded defaults when no TierProvider is
+// configured or none of it resolves a named tier.
+func (r *RateLimitMiddleware) TierForContext(c *gin.Context) Tier {
+	if r.TierProvider == nil {
+		return fallbackTier()
+	}
+	return r.TierProvider.TierForRequest(c)
+}
+
+// limiterForTier returns (and lazily builds) a per-minute limiter bound to
+// tier.PublicCollectionUploadURLsPerMin, caching one instance per tier name
+// so we don't reallocate a limiter on every request.
+func (r *RateLimitMiddleware) limiterForTier(tier Tier) *limiter.Limiter {
+	r.tierLimitersMu.RLock()
+	l, ok := r.tierLimiters[tier.Name]
+	r.tierLimitersMu.RUnlock()
+	if ok {
+		return l
+	}
+
+	r.tierLimitersMu.Lock()
+	defer r.tierLimitersMu.Unlock()
+	if l, ok := r.tierLimiters[tier.Name]; ok {
+		return l
+	}
+	l = util.NewRateLimiter(fmt.Sprintf("%d-M", tier.PublicCollectionUploadURLsPerMin))
+	r.tierLimiters[tier.Name] = l
+	return l
 }
 
-// Increment increments the counter in a thread-safe manner.
-// Returns true if the increment was within the rate limit, false if the rate limit was exceeded.
-func (r *RateLimitMiddleware) Increment() bool {
-	// Atomically increment the count
-	newCount := atomic.AddInt64(&r.count, 1)
-	return newCount <= r.limit
+// visitorLimiterForTier returns (and lazily builds) the VisitorLimiter
+// GlobalRateLimiter should apply for tier, caching one instance per tier
+// name so we don't reallocate a limiter (and its LRU) on every request.
+// Tiers that don't override RequestLimitBurst/RequestLimitReplenish fall
+// back to the shared default visitorLimiter instead of getting an entry
+// here.
+func (r *RateLimitMiddleware) visitorLimiterForTier(tier Tier) *VisitorLimiter {
+	if tier.RequestLimitBurst <= 0 || tier.RequestLimitReplenish <= 0 {
+		return r.visitorLimiter
+	}
+
+	r.tierVisitorLimitersMu.RLock()
+	l, ok := r.tierVisitorLimiters[tier.Name]
+	r.tierVisitorLimitersMu.RUnlock()
+	if ok {
+		return l
+	}
+
+	r.tierVisitorLimitersMu.Lock()
+	defer r.tierVisitorLimitersMu.Unlock()
+	if l, ok := r.tierVisitorLimiters[tier.Name]; ok {
+		return l
+	}
+	l = NewVisitorLimiter(tier.RequestLimitBurst, tier.RequestLimitReplenish)
+	r.tierVisitorLimiters[tier.Name] = l
+	return l
 }
 
-// Stop the internal ticker, effectively stopping the rate limiter.
-func (r *RateLimitMiddleware) Stop() {
-	r.ticker.Stop()
+// VisitorBucketState reports the caller's current global-limiter bucket
+// state (the same tier-aware VisitorLimiter GlobalRateLimiter enforces
+// against) without consuming a token, for GET /users/rate-limits to render
+// alongside the static tier config.
+func (r *RateLimitMiddleware) VisitorBucketState(c *gin.Context) (limit int64, tokensRemaining float64) {
+	return r.visitorLimiterForTier(r.TierForContext(c)).Peek(VisitorKey(c))
 }
 
 // GlobalRateLimiter rate limits all requests to the server, regardless of the endpoint.
 func (r *RateLimitMiddleware) GlobalRateLimiter() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !r.Increment() {
-			if r.count%100 == 0 {
-				go r.discordCtrl.NotifyPotentialAbuse(fmt.Sprintf("Global ratelimit (%d) breached %d", r.limit, r.count))
-			}
+		ip := network.GetClientIP(c)
+		if r.visitorLimiter.IsExempt(ip, c.Request.Host) {
+			c.Next()
+			return
+		}
+
+		visitorLimiter := r.visitorLimiterForTier(r.TierForContext(c))
+		key := VisitorKey(c)
+		allowed, limit, tokens := visitorLimiter.Allow(key)
+		if !allowed {
+			log.WithFields(log.Fields{
+				"visitor_request_limiter_limit":  limit,
+				"visitor_request_limiter_tokens": tokens,
+			}).Warn(fmt.Sprintf("Global rate limit breached for visitor %s", key))
+			go r.discordCtrl.NotifyPotentialAbuse(fmt.Sprintf("Global ratelimit (%d) breached by visitor %s", limit, key))
 			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit breached, try later"})
 			return
 		}
@@ -102,7 +206,12 @@ func (r *RateLimitMiddleware) APIRateLimitMiddleware(urlSanitizer func(_ *gin.Co
 			}
 		}
 
+		tier := r.TierForContext(c)
+
 		rateLimiter := r.getLimiter(requestPath, c.Request.Method)
+		if isPublicCollectionUploadURLPath(requestPath) && tier.PublicCollectionUploadURLsPerMin > 0 {
+			rateLimiter = r.limiterForTier(tier)
+		}
 		if rateLimiter != nil {
 			key := r.getRateLimitKey(c, requestPath)
 			limitContext, err := rateLimiter.Get(c, key)
@@ -118,6 +227,10 @@ func (r *RateLimitMiddleware) APIRateLimitMiddleware(urlSanitizer func(_ *gin.Co
 				return
 			}
 		}
+
+		if !r.enforceDailyQuota(c, requestPath, tier) {
+			return
+		}
 		c.Next()
 	}
 }
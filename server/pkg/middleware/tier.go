@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/ente-io/museum/pkg/repo"
+	"github.com/ente-io/museum/pkg/utils/auth"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Tier names understood by the default tier provider. These are only seed
+// values — operators can define additional tiers (or override these) under
+// the `ratelimit.tiers` config section without a redeploy.
+const (
+	TierFree   = "free"
+	TierPlus   = "plus"
+	TierFamily = "family"
+	TierAdmin  = "admin"
+)
+
+// Tier describes the rate-limit allowances that apply once a caller has
+// been classified into a named tier.
+type Tier struct {
+	Name                             string
+	RequestLimitBurst                int64
+	RequestLimitReplenish            time.Duration
+	PasteCreateDailyLimit            int64
+	PublicCollectionUploadURLsPerMin int64
+}
+
+// TierProvider resolves the Tier that should govern rate limiting for a
+// given request. Implementations may consult the authenticated user's
+// subscription, a family/group plan, or fall back to a configured server
+// default.
+type TierProvider interface {
+	TierForRequest(c *gin.Context) Tier
+}
+
+// DefaultTierProvider resolves tiers with precedence: the user's explicit
+// tier -> their group/family plan's tier -> the configured server default
+// tier -> the hardcoded fallback tier that reproduces today's limits. Tier
+// definitions are loaded from YAML config so operators can add tiers and
+// reassign users without a redeploy.
+type DefaultTierProvider struct {
+	UserRepo    *repo.UserRepository
+	tiers       map[string]Tier
+	defaultTier Tier
+}
+
+// NewDefaultTierProvider loads tier definitions from the `ratelimit.tiers`
+// config section. A server default tier that isn't present in config falls
+// back to today's hardcoded limits, so a fresh install behaves exactly as
+// it did before tiers existed. A user or family plan tier that isn't
+// present in config is skipped, and the next step of the precedence
+// applies.
+func NewDefaultTierProvider(userRepo *repo.UserRepository) *DefaultTierProvider {
+	tiers := map[string]Tier{
+		TierFree: fallbackTier(),
+	}
+	for name := range viper.GetStringMap("ratelimit.tiers") {
+		key := "ratelimit.tiers." + name
+		tiers[name] = Tier{
+			Name:                             name,
+			RequestLimitBurst:                viper.GetInt64(key + ".request-limit-burst"),
+			RequestLimitReplenish:            viper.GetDuration(key + ".request-limit-replenish"),
+			PasteCreateDailyLimit:            viper.GetInt64(key + ".paste-create-daily-limit"),
+			PublicCollectionUploadURLsPerMin: viper.GetInt64(key + ".public-collection-upload-urls-per-min"),
+		}
+	}
+
+	defaultTier, ok := tiers[viper.GetString("ratelimit.default-tier")]
+	if !ok {
+		defaultTier = fallbackTier()
+	}
+
+	return &DefaultTierProvider{
+		UserRepo:    userRepo,
+		tiers:       tiers,
+		defaultTier: defaultTier,
+	}
+}
+
+// TierForRequest implements TierProvider.
+func (p *DefaultTierProvider) TierForRequest(c *gin.Context) Tier {
+	userID := auth.GetUserID(c.Request.Header)
+	if userID != 0 && p.UserRepo != nil {
+		tierName, ok, err := p.UserRepo.GetTier(userID)
+		if err != nil {
+			log.WithError(err).WithField("user_id", userID).Error("Failed to look up user tier")
+		} else if ok {
+			if tier, ok := p.tiers[tierName]; ok {
+				return tier
+			}
+		}
+
+		familyTierName, ok, err := p.UserRepo.GetFamilyPlanTier(userID)
+		if err != nil {
+			log.WithError(err).WithField("user_id", userID).Error("Failed to look up family plan tier")
+		} else if ok {
+			if tier, ok := p.tiers[familyTierName]; ok {
+				return tier
+			}
+		}
+	}
+	return p.defaultTier
+}
+
+// fallbackTier reproduces today's hardcoded limits, so a tier with no
+// explicit configuration behaves exactly as it did before tiers existed.
+func fallbackTier() Tier {
+	return Tier{
+		Name:                             TierFree,
+		RequestLimitBurst:                200,
+		RequestLimitReplenish:            time.Minute / 200,
+		PasteCreateDailyLimit:            0,
+		PublicCollectionUploadURLsPerMin: 50,
+	}
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/ente-io/museum/pkg/repo"
+	"github.com/ente-io/museum/pkg/utils/auth"
+	"github.com/ente-io/museum/pkg/utils/network"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultTokenActivityDebounce bounds how often a single token's
+// last_seen_at/ip/user_agent are written, so an authenticated client's hot
+// path isn't hit with a write on every request.
+const defaultTokenActivityDebounce = 5 * time.Minute
+
+const (
+	// tokenActivityMaxEntries bounds the debounce LRU the same way
+	// visitorLimiterMaxEntries bounds VisitorLimiter's buckets, so a flood
+	// of distinct tokens can't grow lastWrite without bound.
+	tokenActivityMaxEntries = 100_000
+	// tokenActivityIdleEvictAfter evicts a token's debounce entry once it's
+	// gone this long without a request — well past defaultTokenActivityDebounce,
+	// so it only trims tokens that have genuinely gone quiet.
+	tokenActivityIdleEvictAfter = 30 * time.Minute
+)
+
+// TokenActivityMiddleware records per-token last-seen metadata so
+// InactiveUserOrchestrator and `GET /users/sessions` can see, per token,
+// when and where it was last used — instead of relying on a single
+// aggregate timestamp for the whole account.
+type TokenActivityMiddleware struct {
+	UserRepo *repo.UserRepository
+	debounce time.Duration
+
+	mu        sync.Mutex
+	lastWrite map[string]*list.Element
+	order     *list.List // front = most recently used
+}
+
+type tokenActivityEntry struct {
+	token string
+	seen  time.Time
+}
+
+func NewTokenActivityMiddleware(userRepo *repo.UserRepository) *TokenActivityMiddleware {
+	return &TokenActivityMiddleware{
+		UserRepo:  userRepo,
+		debounce:  defaultTokenActivityDebounce,
+		lastWrite: make(map[string]*list.Element),
+		order:     list.New(),
+	}
+}
+
+// RecordActivity updates the authenticated token's last-seen metadata,
+// debounced so we write at most once per debounce interval per token. It's
+// meant to run after auth has resolved the request's token, and never
+// blocks or fails the request — a missed write just means a slightly
+// stale "last used" timestamp.
+func (t *TokenActivityMiddleware) RecordActivity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := auth.GetToken(c.Request.Header)
+		if token != "" && t.shouldWrite(token) {
+			ip := network.GetClientIP(c)
+			userAgent := c.Request.UserAgent()
+			go func() {
+				if err := t.UserRepo.UpdateTokenLastSeen(token, time.Now().UnixMicro(), ip, userAgent); err != nil {
+					log.WithError(err).Error("Failed to record token activity")
+				}
+			}()
+		}
+		c.Next()
+	}
+}
+
+// shouldWrite reports whether enough time has passed since the last write
+// for this token to justify another one, and if so marks it as written.
+// The debounce entries live in a bounded LRU, mirroring VisitorLimiter's
+// buckets, so the set of tokens a server has ever seen doesn't grow this
+// map forever.
+func (t *TokenActivityMiddleware) shouldWrite(token string) bool {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if el, ok := t.lastWrite[token]; ok {
+		entry := el.Value.(*tokenActivityEntry)
+		if now.Sub(entry.seen) < t.debounce {
+			t.order.MoveToFront(el)
+			return false
+		}
+		entry.seen = now
+		t.order.MoveToFront(el)
+		return true
+	}
+
+	el := t.order.PushFront(&tokenActivityEntry{token: token, seen: now})
+	t.lastWrite[token] = el
+	t.evictLocked(now)
+	return true
+}
+
+// evictLocked drops the least-recently-used debounce entry(ies) once the
+// LRU is over capacity, plus anything idle past tokenActivityIdleEvictAfter.
+// The back of the list is the least-recently-used entry, so the idle sweep
+// can stop at the first one that's still fresh. Caller must hold t.mu.
+func (t *TokenActivityMiddleware) evictLocked(now time.Time) {
+	for t.order.Len() > tokenActivityMaxEntries {
+		t.evictBack()
+	}
+	for {
+		back := t.order.Back()
+		if back == nil {
+			break
+		}
+		if now.Sub(back.Value.(*tokenActivityEntry).seen) < tokenActivityIdleEvictAfter {
+			break
+		}
+		t.evictBack()
+	}
+}
+
+func (t *TokenActivityMiddleware) evictBack() {
+	back := t.order.Back()
+	if back == nil {
+		return
+	}
+	entry := t.order.Remove(back).(*tokenActivityEntry)
+	delete(t.lastWrite, entry.token)
+}
@@ -0,0 +1,231 @@
+package middleware
+
+import (
+	"container/list"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ente-io/museum/pkg/utils/auth"
+	"github.com/ente-io/museum/pkg/utils/network"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// visitorLimiterMaxEntries bounds the LRU so a flood of distinct
+	// visitors can't grow the bucket map without bound.
+	visitorLimiterMaxEntries = 100_000
+	// visitorLimiterIdleEvictAfter evicts a visitor's bucket once it's gone
+	// this long without a request.
+	visitorLimiterIdleEvictAfter = 30 * time.Minute
+)
+
+var (
+	visitorRequestsAllowed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "museum_visitor_rate_limiter_allowed_total",
+		Help: "Requests allowed by the per-visitor global rate limiter.",
+	})
+	visitorRequestsDenied = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "museum_visitor_rate_limiter_denied_total",
+		Help: "Requests denied by the per-visitor global rate limiter.",
+	})
+	visitorBucketsEvicted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "museum_visitor_rate_limiter_evicted_total",
+		Help: "Visitor buckets evicted from the rate limiter's LRU, whether idle or over capacity.",
+	})
+)
+
+// visitorBucket is a token bucket for a single visitor: it holds at most
+// burst tokens, refilling one token every replenish interval.
+type visitorBucket struct {
+	tokens     float64
+	lastRefill time.Time
+	lastSeen   time.Time
+}
+
+type visitorEntry struct {
+	key    string
+	bucket *visitorBucket
+}
+
+// VisitorLimiter rate limits requests per-visitor (client IP, plus the
+// authenticated user ID when present) with a token bucket, replacing the
+// single global fixed-window counter this middleware used to keep. It
+// keeps a bounded LRU of visitor buckets, with idle eviction, so memory
+// doesn't grow unboundedly under a flood of distinct clients.
+type VisitorLimiter struct {
+	burst     float64
+	replenish time.Duration
+
+	mu          sync.Mutex
+	exemptCIDRs []*net.IPNet
+	exemptHosts map[string]bool
+	buckets     map[string]*list.Element
+	order       *list.List // front = most recently used
+}
+
+// NewVisitorLimiter creates a token-bucket limiter with the given burst
+// size and replenish interval, e.g. burst=200, replenish=300ms mirrors a
+// 200 req/min allowance that can still burst up to 200 requests at once.
+func NewVisitorLimiter(burst int64, replenish time.Duration) *VisitorLimiter {
+	return &VisitorLimiter{
+		burst:     float64(burst),
+		replenish: replenish,
+		buckets:   make(map[string]*list.Element),
+		order:     list.New(),
+	}
+}
+
+// SetExemptHosts configures a comma-separated list of CIDRs and hostnames
+// (RateLimitExemptHosts) that should short-circuit the limiter entirely —
+// trusted infra, load balancers, and internal jobs.
+func (v *VisitorLimiter) SetExemptHosts(raw string) {
+	var cidrs []*net.IPNet
+	hosts := make(map[string]bool)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			cidrs = append(cidrs, cidr)
+			continue
+		}
+		hosts[entry] = true
+	}
+	v.mu.Lock()
+	v.exemptCIDRs = cidrs
+	v.exemptHosts = hosts
+	v.mu.Unlock()
+}
+
+// IsExempt reports whether ip or host should bypass rate limiting.
+func (v *VisitorLimiter) IsExempt(ip string, host string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if host != "" && v.exemptHosts[host] {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, cidr := range v.exemptCIDRs {
+		if cidr.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// VisitorKey derives the bucket key for a request: the client IP, plus the
+// authenticated user ID when present, so logged-in users behind one shared
+// IP each get their own bucket. A user's requests from different IPs land
+// in different buckets.
+func VisitorKey(c *gin.Context) string {
+	ip := network.GetClientIP(c)
+	if userID := auth.GetUserID(c.Request.Header); userID != 0 {
+		return ip + "|" + strconv.FormatInt(userID, 10)
+	}
+	return ip
+}
+
+// Allow consumes a token for key, refilling based on elapsed time since
+// the bucket was last touched. It reports whether the request is allowed,
+// along with the limit and tokens remaining so callers can log why a
+// specific client tripped the limit.
+func (v *VisitorLimiter) Allow(key string) (allowed bool, limit int64, tokensRemaining float64) {
+	now := time.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	el, ok := v.buckets[key]
+	var b *visitorBucket
+	if ok {
+		v.order.MoveToFront(el)
+		b = el.Value.(*visitorEntry).bucket
+	} else {
+		b = &visitorBucket{tokens: v.burst, lastRefill: now, lastSeen: now}
+		el = v.order.PushFront(&visitorEntry{key: key, bucket: b})
+		v.buckets[key] = el
+		v.evictLocked(now)
+	}
+
+	if elapsed := now.Sub(b.lastRefill); elapsed > 0 && v.replenish > 0 {
+		b.tokens = minFloat(v.burst, b.tokens+float64(elapsed)/float64(v.replenish))
+		b.lastRefill = now
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		visitorRequestsDenied.Inc()
+		return false, int64(v.burst), b.tokens
+	}
+	b.tokens--
+	visitorRequestsAllowed.Inc()
+	return true, int64(v.burst), b.tokens
+}
+
+// Peek reports key's current token count and the limit it's bucketed
+// under, without consuming a token or touching the LRU — so a status
+// endpoint like GET /users/rate-limits can show a caller their own bucket
+// state without affecting it. A key with no existing bucket is reported as
+// having a full, unconsumed bucket, since that's what its next Allow call
+// would see.
+func (v *VisitorLimiter) Peek(key string) (limit int64, tokensRemaining float64) {
+	now := time.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	el, ok := v.buckets[key]
+	if !ok {
+		return int64(v.burst), v.burst
+	}
+	b := el.Value.(*visitorEntry).bucket
+	tokens := b.tokens
+	if elapsed := now.Sub(b.lastRefill); elapsed > 0 && v.replenish > 0 {
+		tokens = minFloat(v.burst, tokens+float64(elapsed)/float64(v.replenish))
+	}
+	return int64(v.burst), tokens
+}
+
+// evictLocked drops the least-recently-used bucket(s) once the LRU is over
+// capacity, plus anything idle past visitorLimiterIdleEvictAfter. The back
+// of the list is the least-recently-used entry, so the idle sweep can stop
+// at the first bucket that's still fresh. Caller must hold v.mu.
+func (v *VisitorLimiter) evictLocked(now time.Time) {
+	for v.order.Len() > visitorLimiterMaxEntries {
+		v.evictBack()
+	}
+	for {
+		back := v.order.Back()
+		if back == nil {
+			break
+		}
+		if now.Sub(back.Value.(*visitorEntry).bucket.lastSeen) < visitorLimiterIdleEvictAfter {
+			break
+		}
+		v.evictBack()
+	}
+}
+
+func (v *VisitorLimiter) evictBack() {
+	back := v.order.Back()
+	if back == nil {
+		return
+	}
+	entry := v.order.Remove(back).(*visitorEntry)
+	delete(v.buckets, entry.key)
+	visitorBucketsEvicted.Inc()
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestVisitorLimiter_AllowsUpToBurstThenDenies(t *testing.T) {
+	v := NewVisitorLimiter(3, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if allowed, _, _ := v.Allow("visitor"); !allowed {
+			t.Fatalf("expected request %d to be allowed within burst", i)
+		}
+	}
+	if allowed, _, _ := v.Allow("visitor"); allowed {
+		t.Fatal("expected request past burst to be denied")
+	}
+}
+
+func TestVisitorLimiter_RefillsOverTime(t *testing.T) {
+	v := NewVisitorLimiter(1, time.Minute)
+
+	if allowed, _, _ := v.Allow("visitor"); !allowed {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if allowed, _, _ := v.Allow("visitor"); allowed {
+		t.Fatal("expected the second request to be denied before any refill")
+	}
+
+	// Back-date the bucket's last refill so Allow sees a full replenish
+	// interval having elapsed, without sleeping the test.
+	el := v.buckets["visitor"]
+	el.Value.(*visitorEntry).bucket.lastRefill = time.Now().Add(-time.Minute)
+
+	if allowed, _, _ := v.Allow("visitor"); !allowed {
+		t.Fatal("expected a request to be allowed after a full replenish interval")
+	}
+}
+
+func TestVisitorLimiter_DistinctVisitorsHaveIndependentBuckets(t *testing.T) {
+	v := NewVisitorLimiter(1, time.Hour)
+
+	if allowed, _, _ := v.Allow("visitor-a"); !allowed {
+		t.Fatal("expected visitor-a's first request to be allowed")
+	}
+	if allowed, _, _ := v.Allow("visitor-a"); allowed {
+		t.Fatal("expected visitor-a's second request to be denied")
+	}
+	if allowed, _, _ := v.Allow("visitor-b"); !allowed {
+		t.Fatal("expected visitor-b's first request to be allowed despite visitor-a being rate limited")
+	}
+}
+
+func TestVisitorLimiter_EvictsOverCapacity(t *testing.T) {
+	v := NewVisitorLimiter(1, time.Hour)
+
+	for i := 0; i < visitorLimiterMaxEntries+10; i++ {
+		v.Allow(fmt.Sprintf("visitor-%d", i))
+	}
+
+	if got := len(v.buckets); got > visitorLimiterMaxEntries {
+		t.Fatalf("expected bucket count to stay capped at %d, got %d", visitorLimiterMaxEntries, got)
+	}
+	if got := v.order.Len(); got > visitorLimiterMaxEntries {
+		t.Fatalf("expected LRU list length to stay capped at %d, got %d", visitorLimiterMaxEntries, got)
+	}
+}
+
+func TestVisitorLimiter_EvictsIdleBuckets(t *testing.T) {
+	v := NewVisitorLimiter(1, time.Hour)
+	v.Allow("idle-visitor")
+
+	el := v.buckets["idle-visitor"]
+	el.Value.(*visitorEntry).bucket.lastSeen = time.Now().Add(-2 * visitorLimiterIdleEvictAfter)
+
+	// Any new arrival triggers evictLocked, which should sweep the idle entry.
+	v.Allow("fresh-visitor")
+
+	if _, ok := v.buckets["idle-visitor"]; ok {
+		t.Fatal("expected the idle visitor's bucket to have been evicted")
+	}
+}
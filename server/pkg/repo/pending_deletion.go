@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// PendingDeletion is a user's scheduled-purge state: set when the 12-month
+// inactivity stage fires, cleared either by POST /users/cancel-scheduled-deletion
+// or by ProcessPendingAccountPurges finding activity since it was set.
+type PendingDeletion struct {
+	UserID              int64
+	DeletionRequestedAt int64
+	ScheduledPurgeAt    int64
+}
+
+// UserPendingDeletionCandidate is a row returned by GetUsersPendingPurgeBefore:
+// a user whose scheduled_purge_at has elapsed and who is due for the
+// activity re-check ProcessPendingAccountPurges performs before purging.
+type UserPendingDeletionCandidate struct {
+	UserID              int64
+	DeletionRequestedAt int64
+	ScheduledPurgeAt    int64
+}
+
+// SetPendingDeletion records that userID's account is scheduled for
+// deletion at scheduledPurgeAt unless cancelled or found active again
+// before then. deletionRequestedAt anchors the "no activity since" check
+// ProcessPendingAccountPurges and HandleAutomatedAccountDeletion both run.
+func (r *UserRepository) SetPendingDeletion(userID int64, deletionRequestedAt int64, scheduledPurgeAt int64) error {
+	_, err := r.DB.Exec(`
+		UPDATE users
+		SET deletion_requested_at = $1, scheduled_purge_at = $2
+		WHERE user_id = $3`, deletionRequestedAt, scheduledPurgeAt, userID)
+	return err
+}
+
+// ClearPendingDeletion cancels a scheduled purge for userID, whether
+// because the user cancelled it or because they were found active again.
+func (r *UserRepository) ClearPendingDeletion(userID int64) error {
+	_, err := r.DB.Exec(`
+		UPDATE users
+		SET deletion_requested_at = NULL, scheduled_purge_at = NULL
+		WHERE user_id = $1`, userID)
+	return err
+}
+
+// GetPendingDeletion returns userID's scheduled-purge state, if any. The
+// bool reports whether a pending deletion is currently set.
+func (r *UserRepository) GetPendingDeletion(userID int64) (PendingDeletion, bool, error) {
+	var pending PendingDeletion
+	row := r.DB.QueryRow(`
+		SELECT user_id, deletion_requested_at, scheduled_purge_at
+		FROM users
+		WHERE user_id = $1 AND scheduled_purge_at IS NOT NULL`, userID)
+	if err := row.Scan(&pending.UserID, &pending.DeletionRequestedAt, &pending.ScheduledPurgeAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PendingDeletion{}, false, nil
+		}
+		return PendingDeletion{}, false, err
+	}
+	return pending, true, nil
+}
+
+// GetUsersPendingPurgeBefore returns, in user_id order starting after
+// afterUserID, up to batchSize users whose scheduled_purge_at is at or
+// before now — the candidates ProcessPendingAccountPurges should
+// re-check and, absent newer activity, actually purge.
+func (r *UserRepository) GetUsersPendingPurgeBefore(now int64, afterUserID int64, batchSize int) ([]UserPendingDeletionCandidate, error) {
+	rows, err := r.DB.Query(`
+		SELECT user_id, deletion_requested_at, scheduled_purge_at
+		FROM users
+		WHERE scheduled_purge_at IS NOT NULL
+		  AND scheduled_purge_at <= $1
+		  AND user_id > $2
+		ORDER BY user_id
+		LIMIT $3`, now, afterUserID, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []UserPendingDeletionCandidate
+	for rows.Next() {
+		var candidate UserPendingDeletionCandidate
+		if err := rows.Scan(&candidate.UserID, &candidate.DeletionRequestedAt, &candidate.ScheduledPurgeAt); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
@@ -0,0 +1,41 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// GetTier returns the name of the subscription tier explicitly assigned to
+// userID (e.g. "plus", "admin"), if any. The bool reports whether a tier
+// row was found at all — false means the caller should fall back to the
+// user's family plan tier, then the server default.
+func (r *UserRepository) GetTier(userID int64) (string, bool, error) {
+	var tierName string
+	row := r.DB.QueryRow(`SELECT tier FROM user_tiers WHERE user_id = $1`, userID)
+	if err := row.Scan(&tierName); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return tierName, true, nil
+}
+
+// GetFamilyPlanTier returns the name of the subscription tier inherited
+// through userID's family plan, if they belong to one. Consulted only when
+// GetTier finds no tier assigned directly to the user.
+func (r *UserRepository) GetFamilyPlanTier(userID int64) (string, bool, error) {
+	var tierName string
+	row := r.DB.QueryRow(`
+		SELECT ut.tier
+		FROM family_members fm
+		JOIN user_tiers ut ON ut.user_id = fm.admin_user_id
+		WHERE fm.user_id = $1`, userID)
+	if err := row.Scan(&tierName); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return tierName, true, nil
+}
@@ -0,0 +1,47 @@
+package repo
+
+// TokenActivity is a single active token's identity and last-seen
+// metadata, as surfaced by GET /users/sessions and consumed by
+// InactiveUserOrchestrator.latestTokenActivity.
+type TokenActivity struct {
+	ID                int64
+	App               string
+	CreatedAt         int64
+	LastSeenAt        int64
+	LastSeenIP        string
+	LastSeenUserAgent string
+}
+
+// UpdateTokenLastSeen records that token was just used from ip/userAgent at
+// lastSeenAt (microseconds), called by TokenActivityMiddleware at most once
+// per debounce interval per token.
+func (r *UserRepository) UpdateTokenLastSeen(token string, lastSeenAt int64, ip string, userAgent string) error {
+	_, err := r.DB.Exec(`
+		UPDATE tokens
+		SET last_seen_at = $1, last_seen_ip = $2, last_seen_user_agent = $3
+		WHERE token = $4 AND is_deleted = false`, lastSeenAt, ip, userAgent, token)
+	return err
+}
+
+// GetActiveTokenActivity returns the last-seen metadata for each of
+// userID's active (non-deleted) tokens.
+func (r *UserRepository) GetActiveTokenActivity(userID int64) ([]TokenActivity, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, app, created_at, COALESCE(last_seen_at, 0), COALESCE(last_seen_ip, ''), COALESCE(last_seen_user_agent, '')
+		FROM tokens
+		WHERE user_id = $1 AND is_deleted = false`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []TokenActivity
+	for rows.Next() {
+		var t TokenActivity
+		if err := rows.Scan(&t.ID, &t.App, &t.CreatedAt, &t.LastSeenAt, &t.LastSeenIP, &t.LastSeenUserAgent); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
@@ -0,0 +1,19 @@
+package repo
+
+import (
+	"database/sql"
+)
+
+// UserRepository is the persistence layer backing user accounts and the
+// subscription/tier, inactivity, and session workflows built on top of
+// them.
+type UserRepository struct {
+	DB *sql.DB
+}
+
+// User is the subset of the users table the workflows in this package care
+// about.
+type User struct {
+	ID    int64
+	Email string
+}